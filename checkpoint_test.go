@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"code.vegaprotocol.io/vega/datanode/entities"
+)
+
+func TestSaveAndLoadCheckpointRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	end := time.Date(2024, 1, 1, 0, 5, 0, 0, time.UTC)
+	liveOrders := map[entities.OrderID]Order{
+		entities.OrderID("o1"): testOrder("o1", "m1", entities.OrderStatusActive, 10),
+	}
+
+	if err := SaveCheckpoint(dir, end, liveOrders); err != nil {
+		t.Fatalf("SaveCheckpoint: %v", err)
+	}
+
+	got, ok, err := LoadLatestCheckpoint(dir)
+	if err != nil {
+		t.Fatalf("LoadLatestCheckpoint: %v", err)
+	}
+	if !ok {
+		t.Fatal("expected a checkpoint to be found")
+	}
+	if !got.BucketEnd.Equal(end) {
+		t.Fatalf("BucketEnd = %s, want %s", got.BucketEnd, end)
+	}
+	if len(got.LiveOrders) != 1 {
+		t.Fatalf("LiveOrders = %d, want 1", len(got.LiveOrders))
+	}
+}
+
+func TestLoadLatestCheckpointSkipsCorruptSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	older := time.Date(2024, 1, 1, 0, 5, 0, 0, time.UTC)
+	newer := time.Date(2024, 1, 1, 0, 10, 0, 0, time.UTC)
+
+	liveOrders := map[entities.OrderID]Order{
+		entities.OrderID("o1"): testOrder("o1", "m1", entities.OrderStatusActive, 10),
+	}
+	if err := SaveCheckpoint(dir, older, liveOrders); err != nil {
+		t.Fatalf("SaveCheckpoint(older): %v", err)
+	}
+	if err := SaveCheckpoint(dir, newer, liveOrders); err != nil {
+		t.Fatalf("SaveCheckpoint(newer): %v", err)
+	}
+
+	// Simulate the newest snapshot being damaged on disk.
+	if err := os.WriteFile(snapshotPath(dir, newer), []byte("not a valid gob stream"), 0o644); err != nil {
+		t.Fatalf("corrupting snapshot: %v", err)
+	}
+
+	got, ok, err := LoadLatestCheckpoint(dir)
+	if err != nil {
+		t.Fatalf("LoadLatestCheckpoint: %v", err)
+	}
+	if !ok {
+		t.Fatal("expected a fallback to the older, valid snapshot")
+	}
+	if !got.BucketEnd.Equal(older) {
+		t.Fatalf("BucketEnd = %s, want fallback to %s", got.BucketEnd, older)
+	}
+}
+
+func TestLoadLatestCheckpointNoneExist(t *testing.T) {
+	dir := t.TempDir()
+	_, ok, err := LoadLatestCheckpoint(dir)
+	if err != nil {
+		t.Fatalf("LoadLatestCheckpoint: %v", err)
+	}
+	if ok {
+		t.Fatal("expected no checkpoint to be found in an empty dir")
+	}
+}
@@ -0,0 +1,53 @@
+// Package depthpb holds hand-written, wire-compatible stand-ins for the
+// types proto/depth.proto describes. They are not protoc-gen-go output:
+// there's no protoc/buf toolchain wired into this repo yet, so these
+// implement just enough of the generated-code surface (proto.Message,
+// gRPC server/client stubs) for GRPCDepthSink to run against, by hand.
+// If protoc-gen-go/protoc-gen-go-grpc generation is ever added for
+// proto/depth.proto, these files should be replaced with its output.
+package depthpb
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type PriceLevel struct {
+	Side   string `protobuf:"bytes,1,opt,name=side,proto3" json:"side,omitempty"`
+	Price  string `protobuf:"bytes,2,opt,name=price,proto3" json:"price,omitempty"`
+	Volume int64  `protobuf:"varint,3,opt,name=volume,proto3" json:"volume,omitempty"`
+}
+
+func (x *PriceLevel) Reset()         { *x = PriceLevel{} }
+func (x *PriceLevel) String() string { return fmt.Sprintf("%+v", *x) }
+func (*PriceLevel) ProtoMessage()    {}
+
+type MarketDepth struct {
+	MarketId string        `protobuf:"bytes,1,opt,name=market_id,json=marketId,proto3" json:"market_id,omitempty"`
+	Levels   []*PriceLevel `protobuf:"bytes,2,rep,name=levels,proto3" json:"levels,omitempty"`
+}
+
+func (x *MarketDepth) Reset()         { *x = MarketDepth{} }
+func (x *MarketDepth) String() string { return fmt.Sprintf("%+v", *x) }
+func (*MarketDepth) ProtoMessage()    {}
+
+type DepthSnapshot struct {
+	BucketEnd *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=bucket_end,json=bucketEnd,proto3" json:"bucket_end,omitempty"`
+	Markets   []*MarketDepth         `protobuf:"bytes,2,rep,name=markets,proto3" json:"markets,omitempty"`
+}
+
+func (x *DepthSnapshot) Reset()         { *x = DepthSnapshot{} }
+func (x *DepthSnapshot) String() string { return fmt.Sprintf("%+v", *x) }
+func (*DepthSnapshot) ProtoMessage()    {}
+
+type SubscribeRequest struct {
+	MarketIds []string `protobuf:"bytes,1,rep,name=market_ids,json=marketIds,proto3" json:"market_ids,omitempty"`
+}
+
+func (x *SubscribeRequest) Reset()         { *x = SubscribeRequest{} }
+func (x *SubscribeRequest) String() string { return fmt.Sprintf("%+v", *x) }
+func (*SubscribeRequest) ProtoMessage()    {}
+
+var _ proto.Message = (*DepthSnapshot)(nil)
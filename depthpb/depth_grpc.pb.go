@@ -0,0 +1,95 @@
+// Hand-written server/client stubs for proto/depth.proto's DepthStream
+// service, matching the shape protoc-gen-go-grpc output would have. See
+// depth.pb.go for why these aren't actually generated.
+package depthpb
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+type DepthStreamServer interface {
+	Subscribe(*SubscribeRequest, DepthStream_SubscribeServer) error
+}
+
+type DepthStream_SubscribeServer interface {
+	Send(*DepthSnapshot) error
+	grpc.ServerStream
+}
+
+type depthStreamSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (s *depthStreamSubscribeServer) Send(m *DepthSnapshot) error {
+	return s.ServerStream.SendMsg(m)
+}
+
+func _DepthStream_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DepthStreamServer).Subscribe(m, &depthStreamSubscribeServer{stream})
+}
+
+func RegisterDepthStreamServer(s grpc.ServiceRegistrar, srv DepthStreamServer) {
+	s.RegisterService(&DepthStream_ServiceDesc, srv)
+}
+
+var DepthStream_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "depthexporter.v1.DepthStream",
+	HandlerType: (*DepthStreamServer)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _DepthStream_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+}
+
+type DepthStreamClient interface {
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (DepthStream_SubscribeClient, error)
+}
+
+type depthStreamClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDepthStreamClient(cc grpc.ClientConnInterface) DepthStreamClient {
+	return &depthStreamClient{cc}
+}
+
+func (c *depthStreamClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (DepthStream_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DepthStream_ServiceDesc.Streams[0], "/depthexporter.v1.DepthStream/Subscribe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &depthStreamSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DepthStream_SubscribeClient interface {
+	Recv() (*DepthSnapshot, error)
+	grpc.ClientStream
+}
+
+type depthStreamSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *depthStreamSubscribeClient) Recv() (*DepthSnapshot, error) {
+	m := new(DepthSnapshot)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// blockUpdatesChannel is the Postgres NOTIFY channel the datanode publishes
+// to whenever a new block is committed.
+const blockUpdatesChannel = "block_updates"
+
+// waitForNextBucket blocks until the chain has advanced far enough that the
+// bucket ending at end can be closed, returning the latest known block time
+// once it has. It listens on blockUpdatesChannel for new-block notifications
+// and falls back to re-checking on every pollInterval tick in case LISTEN is
+// unavailable or a notification is missed. It returns early with ctx.Err()
+// if ctx is cancelled while waiting, so callers can shut down gracefully
+// instead of blocking forever at head.
+func waitForNextBucket(ctx context.Context, pool *pgxpool.Pool, end time.Time, pollInterval time.Duration) (time.Time, error) {
+	poolConn, err := pool.Acquire(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to acquire connection to listen for block updates: %w", err)
+	}
+	defer poolConn.Release()
+	conn := poolConn.Conn()
+
+	// If LISTEN fails, wait on pollInterval alone.
+	_, listenErr := conn.Exec(ctx, fmt.Sprintf("listen %s", blockUpdatesChannel))
+	listening := listenErr == nil
+
+	for {
+		lastBlockTime, err := getLastBlockTime(ctx, pool)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to get last block: %w", err)
+		}
+		if !end.After(lastBlockTime) {
+			return lastBlockTime, nil
+		}
+
+		if !listening {
+			select {
+			case <-ctx.Done():
+				return time.Time{}, ctx.Err()
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+
+		waitCtx, cancel := context.WithTimeout(ctx, pollInterval)
+		_, err = conn.WaitForNotification(waitCtx)
+		cancel()
+		if err != nil && ctx.Err() != nil {
+			return time.Time{}, ctx.Err()
+		}
+		// Either a notification arrived or the poll interval elapsed; loop
+		// round and re-check head either way.
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"code.vegaprotocol.io/vega/datanode/entities"
+	"github.com/pscott31/depthexporter/depthpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// subscriberQueueSize bounds how many unsent snapshots a subscriber can fall
+// behind by before buckets start being dropped for it. Content-addressable
+// streaming services (e.g. containerd's content store) use the same
+// bounded-channel-plus-drop approach to stop one slow client from applying
+// backpressure to everyone else.
+const subscriberQueueSize = 8
+
+// maxConsecutiveMisses is how many buckets in a row a subscriber can fail to
+// keep up with before it is disconnected outright, rather than left
+// indefinitely receiving a dropped, incomplete view of depth.
+const maxConsecutiveMisses = 3
+
+type grpcSubscriber struct {
+	markets  map[string]bool // empty/nil means "all markets"
+	queue    chan *depthpb.DepthSnapshot
+	done     chan struct{}
+	kill     chan struct{}
+	killOnce sync.Once
+
+	mu     sync.Mutex
+	missed int
+}
+
+func (s *grpcSubscriber) wants(marketID string) bool {
+	if len(s.markets) == 0 {
+		return true
+	}
+	return s.markets[marketID]
+}
+
+// recordDelivered resets the consecutive-miss counter after a successful
+// send.
+func (s *grpcSubscriber) recordDelivered() {
+	s.mu.Lock()
+	s.missed = 0
+	s.mu.Unlock()
+}
+
+// recordMissed counts a dropped bucket and disconnects the subscriber once
+// it's missed maxConsecutiveMisses in a row.
+func (s *grpcSubscriber) recordMissed() {
+	s.mu.Lock()
+	s.missed++
+	tooSlow := s.missed >= maxConsecutiveMisses
+	s.mu.Unlock()
+
+	if tooSlow {
+		s.killOnce.Do(func() { close(s.kill) })
+	}
+}
+
+// GRPCDepthSink runs a gRPC server that streams a DepthSnapshot message to
+// every subscribed client once per bucket. Each subscriber gets its own
+// bounded queue; a client that falls behind has buckets silently dropped
+// for up to maxConsecutiveMisses in a row, after which it is disconnected
+// rather than being allowed to keep stalling on an ever-more-stale queue.
+type GRPCDepthSink struct {
+	listener net.Listener
+	server   *grpc.Server
+
+	mu          sync.Mutex
+	subscribers map[*grpcSubscriber]struct{}
+}
+
+func NewGRPCDepthSink(addr string) (*GRPCDepthSink, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	sink := &GRPCDepthSink{
+		listener:    lis,
+		subscribers: map[*grpcSubscriber]struct{}{},
+	}
+
+	sink.server = grpc.NewServer()
+	depthpb.RegisterDepthStreamServer(sink.server, sink)
+
+	go func() {
+		_ = sink.server.Serve(lis)
+	}()
+
+	return sink, nil
+}
+
+// Subscribe implements depthpb.DepthStreamServer. It registers a subscriber
+// for the duration of the stream and forwards snapshots sent to it by
+// WriteBucket until the client disconnects.
+func (s *GRPCDepthSink) Subscribe(req *depthpb.SubscribeRequest, stream depthpb.DepthStream_SubscribeServer) error {
+	markets := map[string]bool{}
+	for _, id := range req.MarketIds {
+		markets[id] = true
+	}
+
+	sub := &grpcSubscriber{
+		markets: markets,
+		queue:   make(chan *depthpb.DepthSnapshot, subscriberQueueSize),
+		done:    make(chan struct{}),
+		kill:    make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	s.subscribers[sub] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.subscribers, sub)
+		s.mu.Unlock()
+		close(sub.done)
+	}()
+
+	for {
+		select {
+		case snap := <-sub.queue:
+			if err := stream.Send(snap); err != nil {
+				return err
+			}
+		case <-sub.kill:
+			return status.Error(codes.ResourceExhausted, "subscriber fell too far behind and was disconnected")
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+func (s *GRPCDepthSink) WriteBucket(end time.Time, depth map[entities.MarketID]map[Level]int64) error {
+	s.mu.Lock()
+	subs := make([]*grpcSubscriber, 0, len(s.subscribers))
+	for sub := range s.subscribers {
+		subs = append(subs, sub)
+	}
+	s.mu.Unlock()
+
+	if len(subs) == 0 {
+		return nil
+	}
+
+	byMarket := map[string]*depthpb.MarketDepth{}
+	for marketID, prices := range depth {
+		md := &depthpb.MarketDepth{MarketId: marketID.String()}
+		for level, vol := range prices {
+			md.Levels = append(md.Levels, &depthpb.PriceLevel{
+				Side:   level.side.String(),
+				Price:  level.price.Dec(),
+				Volume: vol,
+			})
+		}
+		byMarket[marketID.String()] = md
+	}
+
+	for _, sub := range subs {
+		snap := &depthpb.DepthSnapshot{BucketEnd: timestamppb.New(end)}
+		for marketID, md := range byMarket {
+			if sub.wants(marketID) {
+				snap.Markets = append(snap.Markets, md)
+			}
+		}
+
+		select {
+		case sub.queue <- snap:
+			sub.recordDelivered()
+		case <-sub.done:
+		default:
+			// Subscriber is too far behind to keep up; drop this snapshot
+			// rather than block the exporter on a slow client. Enough
+			// consecutive drops disconnects it instead.
+			sub.recordMissed()
+		}
+	}
+	return nil
+}
+
+// VerifyBucket always succeeds: the gRPC sink streams snapshots to whatever
+// clients are currently subscribed and persists nothing of its own to
+// verify against, so --resume has nothing to check here.
+func (s *GRPCDepthSink) VerifyBucket(end time.Time) error {
+	return nil
+}
+
+func (s *GRPCDepthSink) Close() error {
+	s.server.GracefulStop()
+	return nil
+}
@@ -2,11 +2,10 @@ package main
 
 import (
 	"context"
-	"encoding/csv"
 	"fmt"
 	"os"
-	"sort"
-	"strconv"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"code.vegaprotocol.io/vega/datanode/config"
@@ -15,12 +14,10 @@ import (
 	"code.vegaprotocol.io/vega/paths"
 	"github.com/georgysavva/scany/pgxscan"
 	"github.com/holiman/uint256"
-	"github.com/jackc/pgx/v4"
-	"golang.org/x/exp/maps"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/urfave/cli/v2"
 )
 
-var BUCKET_MINUTES = 5
-
 type Order struct {
 	ID          entities.OrderID
 	MarketID    entities.MarketID
@@ -42,127 +39,98 @@ type Level struct {
 	side  entities.Side
 }
 
-func writeDepth(end time.Time, depth map[entities.MarketID]map[Level]int64) error {
-	csvFile, err := os.Create(fmt.Sprintf("depth-%s.csv", end.Format("2006-01-02-15-04")))
-	if err != nil {
-		return fmt.Errorf("failed creating file: %w", err)
-	}
-	defer csvFile.Close()
-
-	for marketID, prices := range depth {
-		csvWriter := csv.NewWriter(csvFile)
-		sprices := maps.Keys(prices)
-		sort.Slice(sprices, func(i, j int) bool { return sprices[j].price.Lt(&sprices[i].price) })
-		for _, level := range sprices {
-			vol := prices[level]
-			record := []string{
-				end.Format(time.RFC3339),
-				marketID.String(),
-				level.side.String(),
-				level.price.Dec(),
-				strconv.FormatInt(vol, 10),
-			}
-			if err := csvWriter.Write(record); err != nil {
-				return fmt.Errorf("failed to write to file: %w", err)
-			}
-		}
-		csvWriter.Flush()
-	}
-	return nil
+type TimeRow struct {
+	Time time.Time
 }
 
-func writeLiveOrders(end time.Time, liveOrders map[entities.OrderID]Order) error {
-	csvFile, err := os.Create(fmt.Sprintf("liveorders-%s.csv", end.Format("2006-01-02-15-04")))
-	if err != nil {
-		return fmt.Errorf("failed creating file: %w", err)
-	}
-	defer csvFile.Close()
-	csvWriter := csv.NewWriter(csvFile)
-
-	for _, order := range liveOrders {
-		record := []string{
-			end.Format(time.RFC3339),
-			order.MarketID.String(),
-			order.PartyID.String(),
-			order.ID.String(),
-			order.Side.String(),
-			order.Price.Dec(),
-			strconv.FormatInt(order.Remaining, 10),
-		}
-
-		if err := csvWriter.Write(record); err != nil {
-			return fmt.Errorf("failed to write to file: %w", err)
-		}
-	}
-	csvWriter.Flush()
-
-	return nil
+func getFirstBlockBucketStart(ctx context.Context, pool *pgxpool.Pool, bucket time.Duration) (time.Time, error) {
+	ret := TimeRow{}
+	q := fmt.Sprintf("select time_bucket('%d seconds', (select vega_time from blocks order by vega_time limit 1)) as time", int64(bucket.Seconds()))
+	err := pgxscan.Get(ctx, pool, &ret, q)
+	return ret.Time, err
 }
 
-func doBucket(ctx context.Context, conn *pgx.Conn, start time.Time, end time.Time, liveOrders map[entities.OrderID]Order) error {
-	query := `
-	   select o.id, o.market_id, o.party_id, o.side, o.price, o.remaining, o.time_in_force, o.type, o.status
-	     from orders o
-	    where vega_time >= $1 and vega_time < $2
-	 order by vega_time, seq_num`
-
-	orders := []Order{}
-	err := pgxscan.Select(ctx, conn, &orders, query, start, end)
-	if err != nil {
-		return fmt.Errorf("failed to query orders", err)
-	}
-
-	for _, order := range orders {
-		if order.isLive() {
-			liveOrders[order.ID] = order
-		} else {
-			delete(liveOrders, order.ID)
-		}
-	}
+func getLastBlockTime(ctx context.Context, pool *pgxpool.Pool) (time.Time, error) {
+	ret := TimeRow{}
+	q := "select vega_time as time from blocks order by vega_time desc limit 1"
+	err := pgxscan.Get(ctx, pool, &ret, q)
+	return ret.Time, err
+}
 
-	depth := map[entities.MarketID]map[Level]int64{}
-	for _, order := range liveOrders {
-		if _, ok := depth[order.MarketID]; !ok {
-			depth[order.MarketID] = map[Level]int64{}
-		}
-		lev := Level{price: order.Price, side: order.Side}
-		depth[order.MarketID][lev] += order.Remaining
+// newDepthSink constructs the DepthSink selected by --sink. The live-orders
+// CSV is always written alongside it; only the depth snapshot has
+// alternative sinks today.
+func newDepthSink(kind, outputDir, grpcAddr string) (DepthSink, error) {
+	switch kind {
+	case "csv":
+		return NewCSVDepthSink(outputDir), nil
+	case "parquet":
+		return NewParquetDepthSink(outputDir), nil
+	case "grpc":
+		return NewGRPCDepthSink(grpcAddr)
+	default:
+		return nil, fmt.Errorf("unknown sink %q", kind)
 	}
+}
 
-	if err := writeDepth(end, depth); err != nil {
-		return fmt.Errorf("failed to write depth csv: %w", err)
+// parseRFC3339Flag parses an optional RFC3339 flag value, returning the zero
+// time if value is empty.
+func parseRFC3339Flag(flagName, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
 	}
-
-	if err := writeLiveOrders(end, liveOrders); err != nil {
-		return fmt.Errorf("failed to write live orders csv: %w", err)
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --%s %q: %w", flagName, value, err)
 	}
-
-	fmt.Printf("bucket ending %s, orders in bucket: %d, live orders at end: %d\n",
-		end,
-		len(orders),
-		len(liveOrders))
-	return nil
+	return t, nil
 }
 
-type TimeRow struct {
-	Time time.Time
+func app() *cli.App {
+	return &cli.App{
+		Name:  "depth-exporter",
+		Usage: "export order-book depth and live-order snapshots from a vega datanode",
+		Flags: []cli.Flag{
+			&cli.DurationFlag{Name: "bucket", Value: 5 * time.Minute, Usage: "bucket size to aggregate depth snapshots over"},
+			&cli.StringFlag{Name: "from", Usage: "RFC3339 lower bound on blocks processed (defaults to the first block)"},
+			&cli.StringFlag{Name: "to", Usage: "RFC3339 upper bound on blocks processed (defaults to chain head)"},
+			&cli.StringSliceFlag{Name: "markets", Usage: "restrict processing to these market IDs (default: all markets)"},
+			&cli.StringFlag{Name: "output-dir", Value: ".", Usage: "directory to write CSV/Parquet output files to"},
+			&cli.BoolFlag{Name: "resume", Value: true, Usage: "resume from the newest on-disk checkpoint instead of rebuilding from the first block"},
+			&cli.StringFlag{Name: "snapshot-dir", Value: ".", Usage: "directory to read/write checkpoint snapshots from"},
+			&cli.BoolFlag{Name: "follow", Usage: "keep running after reaching head, processing new buckets as they close"},
+			&cli.DurationFlag{Name: "poll-interval", Value: 5 * time.Second, Usage: "fallback interval to re-check for new blocks while following, in case LISTEN/NOTIFY misses an update"},
+			&cli.StringFlag{Name: "sink", Value: "csv", Usage: "output sink to use for depth snapshots: csv, parquet or grpc"},
+			&cli.StringFlag{Name: "grpc-addr", Value: ":7766", Usage: "listen address for the gRPC depth stream, when --sink=grpc"},
+			&cli.IntFlag{Name: "prefetch", Value: 4, Usage: "number of buckets' worth of orders to fetch ahead of the folder stage"},
+			&cli.IntFlag{Name: "writers", Value: 2, Usage: "number of concurrent workers serialising bucket snapshots to the sinks"},
+			&cli.StringFlag{Name: "metrics-addr", Usage: "if set, listen address to expose Prometheus metrics on (e.g. :9090)"},
+		},
+		Action: runExport,
+	}
 }
 
-func getFirstBlockBucketStart(ctx context.Context, conn *pgx.Conn) (time.Time, error) {
-	ret := TimeRow{}
-	q := fmt.Sprintf("select time_bucket( '%d minutes', (select vega_time from blocks order by vega_time limit 1)) as time", BUCKET_MINUTES)
-	err := pgxscan.Get(ctx, conn, &ret, q)
-	return ret.Time, err
+func main() {
+	if err := app().Run(os.Args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
-func getLastBlockTime(ctx context.Context, conn *pgx.Conn) (time.Time, error) {
-	ret := TimeRow{}
-	q := "select vega_time as time from blocks order by vega_time desc limit 1"
-	err := pgxscan.Get(ctx, conn, &ret, q)
-	return ret.Time, err
-}
+func runExport(c *cli.Context) error {
+	bucketDuration := c.Duration("bucket")
+	fromBound, err := parseRFC3339Flag("from", c.String("from"))
+	if err != nil {
+		return err
+	}
+	toBound, err := parseRFC3339Flag("to", c.String("to"))
+	if err != nil {
+		return err
+	}
+	marketFilter := c.StringSlice("markets")
+	outputDir := c.String("output-dir")
+	snapshotDir := c.String("snapshot-dir")
 
-func main() {
 	log := logging.NewLoggerFromConfig(
 		logging.NewDefaultConfig()).Named("depth-exporter")
 	vegaPaths := paths.New("")
@@ -175,34 +143,109 @@ func main() {
 
 	ctx := context.Background()
 	connStr := cfg.SQLStore.ConnectionConfig.GetConnectionString()
-	conn, err := pgx.Connect(ctx, connStr)
+	pool, err := pgxpool.Connect(ctx, connStr)
 	if err != nil {
 		log.Fatal("failed to connect to database", logging.Error(err))
 	}
+	defer pool.Close()
 
-	start, err := getFirstBlockBucketStart(ctx, conn)
+	depthSink, err := newDepthSink(c.String("sink"), outputDir, c.String("grpc-addr"))
 	if err != nil {
-		log.Fatal("failed to get first block", logging.Error(err))
+		log.Fatal("failed to create depth sink", logging.Error(err))
 	}
-	end := start.Add(time.Minute * time.Duration(BUCKET_MINUTES))
+	defer depthSink.Close()
+
+	ordersSink := NewCSVOrdersSink(outputDir)
+	defer ordersSink.Close()
+
+	var start time.Time
 	liveOrders := map[entities.OrderID]Order{}
 
+	if c.Bool("resume") {
+		cp, ok, err := LoadLatestCheckpoint(snapshotDir)
+		if err != nil {
+			log.Fatal("failed to load checkpoint", logging.Error(err))
+		}
+		if ok {
+			if err := verifyCheckpoint(depthSink, ordersSink, cp); err != nil {
+				log.Fatal("checkpoint failed verification against emitted sinks", logging.Error(err))
+			}
+			log.Info("resuming from checkpoint", logging.String("bucket_end", cp.BucketEnd.String()))
+			start = cp.BucketEnd
+			liveOrders = cp.LiveOrders
+		}
+	}
+
+	if start.IsZero() {
+		if !fromBound.IsZero() {
+			start = fromBound
+		} else {
+			start, err = getFirstBlockBucketStart(ctx, pool, bucketDuration)
+			if err != nil {
+				log.Fatal("failed to get first block", logging.Error(err))
+			}
+		}
+	}
+	depth := buildDepth(liveOrders)
+
+	if addr := c.String("metrics-addr"); addr != "" {
+		serveMetrics(log, addr)
+	}
+
+	shutdownCtx, stopSignals := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	follow := c.Bool("follow")
+	pollInterval := c.Duration("poll-interval")
+	prefetch := c.Int("prefetch")
+	writers := c.Int("writers")
+
 	for {
-		lastBlockTime, err := getLastBlockTime(ctx, conn)
+		lastBlockTime, err := getLastBlockTime(ctx, pool)
 		if err != nil {
 			log.Fatal("failed to get last block", logging.Error(err))
 		}
+		if !toBound.IsZero() && toBound.Before(lastBlockTime) {
+			lastBlockTime = toBound
+		}
 
-		if end.After(lastBlockTime) {
-			log.Info("done")
-			return
+		ranges := bucketRanges(start, lastBlockTime, bucketDuration)
+		if len(ranges) == 0 {
+			reachedToBound := !toBound.IsZero() && !toBound.After(lastBlockTime)
+			if !follow || reachedToBound {
+				log.Info("reached head, done",
+					logging.String("bucket_end", start.String()),
+					logging.String("last_block_time", lastBlockTime.String()))
+				return nil
+			}
+
+			if shutdownCtx.Err() != nil {
+				log.Info("shutdown requested, exiting at head")
+				return nil
+			}
+
+			if _, err := waitForNextBucket(shutdownCtx, pool, start.Add(bucketDuration), pollInterval); err != nil {
+				if shutdownCtx.Err() != nil {
+					log.Info("shutdown requested while waiting for new blocks")
+					return nil
+				}
+				log.Fatal("failed waiting for new blocks", logging.Error(err))
+			}
+			continue
 		}
 
-		err = doBucket(ctx, conn, start, end, liveOrders)
-		if err != nil {
-			log.Fatal("failed to process bucket", logging.Error(err))
+		if err := runPipeline(shutdownCtx, log, pool, ranges, marketFilter, liveOrders, depth, depthSink, ordersSink, snapshotDir, prefetch, writers); err != nil {
+			if shutdownCtx.Err() != nil {
+				log.Info("shutdown requested, exiting after flushing in-flight buckets")
+				return nil
+			}
+			log.Fatal("failed to process buckets", logging.Error(err))
+		}
+		start = ranges[len(ranges)-1].end
+
+		if shutdownCtx.Err() != nil {
+			log.Info("shutdown requested, exiting after flushing checkpoint")
+			return nil
 		}
-		start = end
-		end = start.Add(time.Minute * time.Duration(BUCKET_MINUTES))
 	}
 }
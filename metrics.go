@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+
+	"code.vegaprotocol.io/vega/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	bucketsProcessedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "depthexporter_buckets_processed_total",
+		Help: "Total number of buckets fully processed and handed off to the sinks.",
+	})
+
+	ordersInBucket = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "depthexporter_orders_in_bucket",
+		Help:    "Number of order rows returned for each processed bucket.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+	})
+
+	liveOrdersGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "depthexporter_live_orders",
+		Help: "Number of orders currently resting in the live-order book.",
+	})
+
+	bucketDurationSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "depthexporter_bucket_duration_seconds",
+		Help:    "Time taken to receive a bucket's fetched orders, fold them and hand its snapshot off to the sinks.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	lagSeconds = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "depthexporter_lag_seconds",
+		Help: "Wall-clock time minus the end of the most recently processed bucket.",
+	})
+)
+
+// serveMetrics starts an HTTP server exposing the collectors above on
+// addr/metrics in the background and returns immediately; it runs until the
+// process exits.
+func serveMetrics(log *logging.Logger, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error("metrics server stopped", logging.Error(err))
+		}
+	}()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"code.vegaprotocol.io/vega/datanode/entities"
+	"github.com/segmentio/parquet-go"
+)
+
+// DepthRow is the row schema written to the Parquet depth sink. Downstream
+// tools (DuckDB, Spark, ...) can query the resulting files directly without
+// needing to understand the exporter's internal types.
+type DepthRow struct {
+	Ts       time.Time `parquet:"ts,timestamp"`
+	MarketID string    `parquet:"market_id"`
+	Side     string    `parquet:"side"`
+	Price    string    `parquet:"price"`
+	Volume   int64     `parquet:"volume"`
+}
+
+// ParquetDepthSink appends one row group per bucket to a single Parquet file
+// per UTC day. Parquet files can't be appended to once their footer has
+// been written, so the sink keeps the current day's writer open across
+// buckets and only finalises it when the day rolls over or the sink is
+// closed. If the exporter is restarted partway through a day, the existing
+// file for that day is left alone and a new numbered part file is started
+// instead of being rewritten.
+//
+// WriteBucket is safe for concurrent use (the exporter's writer pool may
+// call it from more than one goroutine), but row groups land in whatever
+// order the calls arrive in; run with --writers=1 if buckets must appear
+// in strict time order within the file.
+type ParquetDepthSink struct {
+	outputDir string
+
+	mu     sync.Mutex
+	day    string
+	file   *os.File
+	writer *parquet.GenericWriter[DepthRow]
+}
+
+func NewParquetDepthSink(outputDir string) *ParquetDepthSink {
+	return &ParquetDepthSink{outputDir: outputDir}
+}
+
+func (s *ParquetDepthSink) WriteBucket(end time.Time, depth map[entities.MarketID]map[Level]int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	day := end.UTC().Format("2006-01-02")
+	if day != s.day {
+		if err := s.rollToDay(day); err != nil {
+			return err
+		}
+	}
+
+	rows := make([]DepthRow, 0)
+	for marketID, prices := range depth {
+		for level, vol := range prices {
+			rows = append(rows, DepthRow{
+				Ts:       end,
+				MarketID: marketID.String(),
+				Side:     level.side.String(),
+				Price:    level.price.Dec(),
+				Volume:   vol,
+			})
+		}
+	}
+
+	if _, err := s.writer.Write(rows); err != nil {
+		return fmt.Errorf("failed to write parquet row group: %w", err)
+	}
+	return s.writer.Flush()
+}
+
+func (s *ParquetDepthSink) rollToDay(day string) error {
+	if s.writer != nil {
+		if err := s.closeCurrentFile(); err != nil {
+			return err
+		}
+	}
+
+	path := partFilePath(s.outputDir, day)
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create parquet file: %w", err)
+	}
+
+	s.file = f
+	s.writer = parquet.NewGenericWriter[DepthRow](f)
+	s.day = day
+	return nil
+}
+
+// partFilePath picks the first depth-<day>.part<N>.parquet path that
+// doesn't already exist, so a restart mid-day doesn't clobber the previous
+// run's file.
+func partFilePath(outputDir, day string) string {
+	for part := 0; ; part++ {
+		path := filepath.Join(outputDir, fmt.Sprintf("depth-%s.part%d.parquet", day, part))
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+	}
+}
+
+func (s *ParquetDepthSink) closeCurrentFile() error {
+	if err := s.writer.Close(); err != nil {
+		return fmt.Errorf("failed to close parquet writer: %w", err)
+	}
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("failed to close parquet file: %w", err)
+	}
+	s.writer = nil
+	s.file = nil
+	return nil
+}
+
+// VerifyBucket confirms that a part file for end's UTC day exists. Row
+// groups aren't bucket-addressable within the file, so this can't confirm
+// the specific bucket landed, only that the day's file was started.
+func (s *ParquetDepthSink) VerifyBucket(end time.Time) error {
+	day := end.UTC().Format("2006-01-02")
+	matches, err := filepath.Glob(filepath.Join(s.outputDir, fmt.Sprintf("depth-%s.part*.parquet", day)))
+	if err != nil {
+		return fmt.Errorf("failed to glob parquet part files for verification: %w", err)
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("no parquet part file found for day %s", day)
+	}
+	return nil
+}
+
+func (s *ParquetDepthSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.writer == nil {
+		return nil
+	}
+	return s.closeCurrentFile()
+}
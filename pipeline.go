@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"code.vegaprotocol.io/vega/datanode/entities"
+	"code.vegaprotocol.io/vega/logging"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type bucketRange struct {
+	start, end time.Time
+}
+
+// bucketRanges computes the closed sequence of bucket boundaries from start
+// up to (but not including any bucket ending after) to.
+func bucketRanges(start, to time.Time, bucket time.Duration) []bucketRange {
+	ranges := []bucketRange{}
+	for end := start.Add(bucket); !end.After(to); end = end.Add(bucket) {
+		ranges = append(ranges, bucketRange{start: start, end: end})
+		start = end
+	}
+	return ranges
+}
+
+type bucketFetch struct {
+	bucketRange
+	orders []Order
+	err    error
+}
+
+func queryOrders(ctx context.Context, pool *pgxpool.Pool, start, end time.Time, marketIDs []string) ([]Order, error) {
+	query := `
+	   select o.id, o.market_id, o.party_id, o.side, o.price, o.remaining, o.time_in_force, o.type, o.status
+	     from orders o
+	    where vega_time >= $1 and vega_time < $2
+	      and ($3::text[] is null or o.market_id = any($3))
+	 order by vega_time, seq_num`
+
+	// An empty slice means "all markets"; pass nil so the SQL null-check
+	// above takes the unfiltered branch instead of matching nothing.
+	var marketFilter []string
+	if len(marketIDs) > 0 {
+		marketFilter = marketIDs
+	}
+
+	orders := []Order{}
+	if err := pgxscan.Select(ctx, pool, &orders, query, start, end, marketFilter); err != nil {
+		return nil, fmt.Errorf("failed to query orders: %w", err)
+	}
+	return orders, nil
+}
+
+// fetchBuckets pre-fetches each of ranges' orders in parallel, bounded to
+// prefetch buckets in flight at once, over pool. Results are delivered on a
+// channel-of-channels so the folder stage can read them out in the original
+// bucket order even though the underlying queries complete out of order.
+func fetchBuckets(ctx context.Context, pool *pgxpool.Pool, ranges []bucketRange, marketIDs []string, prefetch int) <-chan (<-chan bucketFetch) {
+	out := make(chan (<-chan bucketFetch), prefetch)
+
+	go func() {
+		defer close(out)
+		sem := make(chan struct{}, prefetch)
+
+		for _, r := range ranges {
+			r := r
+
+			// Acquire the prefetch slot before publishing resultCh on out, so
+			// that if ctx is cancelled while out is full we can bail out
+			// without leaving a channel on out that nothing will ever fill.
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			resultCh := make(chan bucketFetch, 1)
+			select {
+			case out <- resultCh:
+			case <-ctx.Done():
+				<-sem
+				return
+			}
+
+			go func() {
+				defer func() { <-sem }()
+				orders, err := queryOrders(ctx, pool, r.start, r.end, marketIDs)
+				resultCh <- bucketFetch{bucketRange: r, orders: orders, err: err}
+				close(resultCh)
+			}()
+		}
+	}()
+
+	return out
+}
+
+// applyBucket folds one bucket's orders into the persistent live-order book
+// and depth map in place, touching only the levels whose volume actually
+// changed rather than rebuilding depth from the full live-order set.
+func applyBucket(liveOrders map[entities.OrderID]Order, depth map[entities.MarketID]map[Level]int64, orders []Order) {
+	for _, order := range orders {
+		if old, existed := liveOrders[order.ID]; existed {
+			oldLevel := Level{price: old.Price, side: old.Side}
+			if levels, ok := depth[old.MarketID]; ok {
+				levels[oldLevel] -= old.Remaining
+				if levels[oldLevel] == 0 {
+					delete(levels, oldLevel)
+				}
+			}
+		}
+
+		if order.isLive() {
+			liveOrders[order.ID] = order
+			if _, ok := depth[order.MarketID]; !ok {
+				depth[order.MarketID] = map[Level]int64{}
+			}
+			lev := Level{price: order.Price, side: order.Side}
+			depth[order.MarketID][lev] += order.Remaining
+		} else {
+			delete(liveOrders, order.ID)
+		}
+	}
+}
+
+// buildDepth computes the depth map from scratch for an initial live-order
+// set, e.g. one just loaded from a checkpoint. It's only ever called once
+// at startup; every bucket after that is applied incrementally.
+func buildDepth(liveOrders map[entities.OrderID]Order) map[entities.MarketID]map[Level]int64 {
+	depth := map[entities.MarketID]map[Level]int64{}
+	for _, order := range liveOrders {
+		if _, ok := depth[order.MarketID]; !ok {
+			depth[order.MarketID] = map[Level]int64{}
+		}
+		lev := Level{price: order.Price, side: order.Side}
+		depth[order.MarketID][lev] += order.Remaining
+	}
+	return depth
+}
+
+func cloneDepth(depth map[entities.MarketID]map[Level]int64) map[entities.MarketID]map[Level]int64 {
+	cp := make(map[entities.MarketID]map[Level]int64, len(depth))
+	for marketID, levels := range depth {
+		lv := make(map[Level]int64, len(levels))
+		for level, vol := range levels {
+			lv[level] = vol
+		}
+		cp[marketID] = lv
+	}
+	return cp
+}
+
+func cloneLiveOrders(liveOrders map[entities.OrderID]Order) map[entities.OrderID]Order {
+	cp := make(map[entities.OrderID]Order, len(liveOrders))
+	for id, o := range liveOrders {
+		cp[id] = o
+	}
+	return cp
+}
+
+type writeJob struct {
+	end        time.Time
+	depth      map[entities.MarketID]map[Level]int64
+	liveOrders map[entities.OrderID]Order
+
+	// done reports the result of writing this job to depthSink/ordersSink.
+	// It's written exactly once, by whichever writer goroutine picks the
+	// job up, and is what lets the checkpoint stage below wait for a
+	// specific bucket's write to finish despite writers running out of
+	// bucket order.
+	done chan error
+}
+
+// runPipeline fetches ranges' orders from pool and hands them off to
+// processBuckets to fold, write and checkpoint. See processBuckets for the
+// stages after fetching.
+func runPipeline(
+	ctx context.Context,
+	log *logging.Logger,
+	pool *pgxpool.Pool,
+	ranges []bucketRange,
+	marketIDs []string,
+	liveOrders map[entities.OrderID]Order,
+	depth map[entities.MarketID]map[Level]int64,
+	depthSink DepthSink,
+	ordersSink OrdersSink,
+	snapshotDir string,
+	prefetch int,
+	writers int,
+) error {
+	fetchOut := fetchBuckets(ctx, pool, ranges, marketIDs, prefetch)
+	return processBuckets(ctx, log, fetchOut, liveOrders, depth, depthSink, ordersSink, snapshotDir, writers)
+}
+
+// processBuckets processes fetchOut (as produced by fetchBuckets) through
+// three connected stages: a single-threaded folder (this goroutine) that
+// applies each bucket's orders into liveOrders/depth in strict bucket
+// order, a pool of writers buckets are handed off to so that serialising
+// snapshots to the sinks overlaps with folding the next ones, and a
+// single-threaded checkpoint stage. Writers may finish out of bucket
+// order, but a checkpoint must never point at a bucket whose sink writes
+// aren't confirmed done, so checkpointing is kept out of the writer pool
+// and run by its own stage that waits on each bucket's write in turn
+// before saving it. Once ctx is cancelled, buckets whose fetch already
+// completed are still folded and written, and processing stops at the first
+// fetch that fails or when fetchOut closes, rather than waiting for every
+// range to finish.
+func processBuckets(
+	ctx context.Context,
+	log *logging.Logger,
+	fetchOut <-chan (<-chan bucketFetch),
+	liveOrders map[entities.OrderID]Order,
+	depth map[entities.MarketID]map[Level]int64,
+	depthSink DepthSink,
+	ordersSink OrdersSink,
+	snapshotDir string,
+	writers int,
+) error {
+	writeJobs := make(chan writeJob, writers)
+
+	var writeWG sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		writeWG.Add(1)
+		go func() {
+			defer writeWG.Done()
+			for job := range writeJobs {
+				var err error
+				if werr := depthSink.WriteBucket(job.end, job.depth); werr != nil {
+					err = fmt.Errorf("failed to write depth bucket: %w", werr)
+				} else if werr := ordersSink.WriteBucket(job.end, job.liveOrders); werr != nil {
+					err = fmt.Errorf("failed to write live orders bucket: %w", werr)
+				}
+				job.done <- err
+			}
+		}()
+	}
+
+	checkpointJobs := make(chan writeJob, writers)
+	checkpointErr := make(chan error, 1)
+	var checkpointWG sync.WaitGroup
+	checkpointWG.Add(1)
+	go func() {
+		defer checkpointWG.Done()
+		for job := range checkpointJobs {
+			if err := <-job.done; err != nil {
+				checkpointErr <- err
+				return
+			}
+			if err := SaveCheckpoint(snapshotDir, job.end, job.liveOrders); err != nil {
+				checkpointErr <- fmt.Errorf("failed to save checkpoint: %w", err)
+				return
+			}
+		}
+	}()
+
+	var pipelineErr error
+	for resultCh := range fetchOut {
+		bucketStarted := time.Now()
+
+		fr := <-resultCh
+		if fr.err != nil {
+			pipelineErr = fr.err
+			break
+		}
+
+		applyBucket(liveOrders, depth, fr.orders)
+
+		job := writeJob{
+			end:        fr.end,
+			depth:      cloneDepth(depth),
+			liveOrders: cloneLiveOrders(liveOrders),
+			done:       make(chan error, 1),
+		}
+
+		select {
+		case writeJobs <- job:
+		case err := <-checkpointErr:
+			pipelineErr = err
+		}
+
+		if pipelineErr == nil {
+			select {
+			case checkpointJobs <- job:
+			case err := <-checkpointErr:
+				pipelineErr = err
+			}
+		}
+
+		bucketsProcessedTotal.Inc()
+		ordersInBucket.Observe(float64(len(fr.orders)))
+		liveOrdersGauge.Set(float64(len(liveOrders)))
+		bucketDurationSeconds.Observe(time.Since(bucketStarted).Seconds())
+		lagSeconds.Set(time.Since(fr.end).Seconds())
+
+		log.With(
+			logging.String("bucket_start", fr.start.String()),
+			logging.String("bucket_end", fr.end.String()),
+			logging.Int("market_count", len(job.depth)),
+		).Info("processed bucket",
+			logging.Int("orders_in_bucket", len(fr.orders)),
+			logging.Int("live_orders", len(liveOrders)))
+
+		if pipelineErr != nil {
+			break
+		}
+	}
+
+	close(writeJobs)
+	writeWG.Wait()
+	close(checkpointJobs)
+	checkpointWG.Wait()
+
+	if pipelineErr == nil && ctx.Err() != nil {
+		// fetchBuckets stopped short because ctx was cancelled rather than
+		// because every range was delivered; report that instead of
+		// returning success on a partially-processed range.
+		pipelineErr = ctx.Err()
+	}
+
+	if pipelineErr != nil {
+		return pipelineErr
+	}
+	select {
+	case err := <-checkpointErr:
+		return err
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"code.vegaprotocol.io/vega/datanode/entities"
+	"github.com/holiman/uint256"
+)
+
+func TestBucketRanges(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	bucket := 5 * time.Minute
+
+	tests := []struct {
+		name string
+		to   time.Time
+		want int
+	}{
+		{"to equals start, no buckets fit", start, 0},
+		{"to short of one full bucket", start.Add(4 * time.Minute), 0},
+		{"to exactly one bucket", start.Add(5 * time.Minute), 1},
+		{"to one bucket short of two", start.Add(9 * time.Minute), 1},
+		{"to exactly three buckets", start.Add(15 * time.Minute), 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := bucketRanges(start, tt.to, bucket)
+			if len(got) != tt.want {
+				t.Fatalf("bucketRanges(%s) = %d ranges, want %d", tt.to, len(got), tt.want)
+			}
+			for i, r := range got {
+				wantStart := start.Add(time.Duration(i) * bucket)
+				if !r.start.Equal(wantStart) || !r.end.Equal(wantStart.Add(bucket)) {
+					t.Fatalf("range %d = [%s, %s), want [%s, %s)", i, r.start, r.end, wantStart, wantStart.Add(bucket))
+				}
+			}
+		})
+	}
+}
+
+var zeroSide entities.Side
+
+func testOrder(id, marketID string, status entities.OrderStatus, remaining int64) Order {
+	return Order{
+		ID:        entities.OrderID(id),
+		MarketID:  entities.MarketID(marketID),
+		PartyID:   entities.PartyID("party-1"),
+		Side:      zeroSide,
+		Price:     *uint256.NewInt(100),
+		Remaining: remaining,
+		Status:    status,
+	}
+}
+
+func levelKey() Level {
+	return Level{price: *uint256.NewInt(100), side: zeroSide}
+}
+
+func TestApplyBucket(t *testing.T) {
+	var unsetStatus entities.OrderStatus // zero value is not OrderStatusActive
+
+	liveOrders := map[entities.OrderID]Order{}
+	depth := map[entities.MarketID]map[Level]int64{}
+
+	// A newly-seen active order adds its remaining volume to depth.
+	applyBucket(liveOrders, depth, []Order{testOrder("o1", "m1", entities.OrderStatusActive, 10)})
+	if got := depth[entities.MarketID("m1")][levelKey()]; got != 10 {
+		t.Fatalf("after new order, depth = %d, want 10", got)
+	}
+	if len(liveOrders) != 1 {
+		t.Fatalf("liveOrders = %d, want 1", len(liveOrders))
+	}
+
+	// A remaining-size update for the same order replaces its old
+	// contribution to the level rather than adding to it.
+	applyBucket(liveOrders, depth, []Order{testOrder("o1", "m1", entities.OrderStatusActive, 4)})
+	if got := depth[entities.MarketID("m1")][levelKey()]; got != 4 {
+		t.Fatalf("after remaining update, depth = %d, want 4", got)
+	}
+
+	// A status transition away from active removes the order and its
+	// volume, and empties the level entirely when it's the last order there.
+	applyBucket(liveOrders, depth, []Order{testOrder("o1", "m1", unsetStatus, 4)})
+	if len(liveOrders) != 0 {
+		t.Fatalf("liveOrders = %d, want 0 after the order goes inactive", len(liveOrders))
+	}
+	if levels, ok := depth[entities.MarketID("m1")]; ok {
+		if _, ok := levels[levelKey()]; ok {
+			t.Fatalf("depth level should have been removed once its only order went inactive")
+		}
+	}
+}
+
+func TestApplyBucketSubtractsWithoutEmptyingSharedLevel(t *testing.T) {
+	liveOrders := map[entities.OrderID]Order{}
+	depth := map[entities.MarketID]map[Level]int64{}
+
+	applyBucket(liveOrders, depth, []Order{
+		testOrder("o1", "m1", entities.OrderStatusActive, 10),
+		testOrder("o2", "m1", entities.OrderStatusActive, 5),
+	})
+	if got := depth[entities.MarketID("m1")][levelKey()]; got != 15 {
+		t.Fatalf("depth = %d, want 15", got)
+	}
+
+	var unsetStatus entities.OrderStatus
+	applyBucket(liveOrders, depth, []Order{testOrder("o1", "m1", unsetStatus, 10)})
+	if got := depth[entities.MarketID("m1")][levelKey()]; got != 5 {
+		t.Fatalf("after o1 cancelled, depth = %d, want 5 (o2 still live)", got)
+	}
+	if _, ok := liveOrders[entities.OrderID("o2")]; !ok {
+		t.Fatalf("o2 should still be in liveOrders")
+	}
+}
+
+func TestBuildDepthMatchesIncrementalApply(t *testing.T) {
+	liveOrders := map[entities.OrderID]Order{
+		entities.OrderID("o1"): testOrder("o1", "m1", entities.OrderStatusActive, 10),
+		entities.OrderID("o2"): testOrder("o2", "m1", entities.OrderStatusActive, 5),
+	}
+
+	got := buildDepth(liveOrders)
+	if v := got[entities.MarketID("m1")][levelKey()]; v != 15 {
+		t.Fatalf("buildDepth = %d, want 15", v)
+	}
+
+	applied := map[entities.MarketID]map[Level]int64{}
+	applyBucket(map[entities.OrderID]Order{}, applied, []Order{liveOrders["o1"], liveOrders["o2"]})
+	if v, want := applied[entities.MarketID("m1")][levelKey()], got[entities.MarketID("m1")][levelKey()]; v != want {
+		t.Fatalf("applyBucket = %d, buildDepth = %d, want them equal", v, want)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"code.vegaprotocol.io/vega/datanode/entities"
+	"code.vegaprotocol.io/vega/logging"
+)
+
+// blockingDepthSink lets a test hold up WriteBucket for specific bucket
+// ends until released, and reports each completed write (a held-up one once
+// released) on wrote so a test can synchronise on it instead of sleeping.
+type blockingDepthSink struct {
+	block map[time.Time]chan struct{}
+	wrote chan time.Time
+}
+
+func (s *blockingDepthSink) WriteBucket(end time.Time, _ map[entities.MarketID]map[Level]int64) error {
+	if ch, ok := s.block[end]; ok {
+		<-ch
+	}
+	s.wrote <- end
+	return nil
+}
+
+func (s *blockingDepthSink) VerifyBucket(time.Time) error { return nil }
+func (s *blockingDepthSink) Close() error                 { return nil }
+
+type noopOrdersSink struct{}
+
+func (noopOrdersSink) WriteBucket(time.Time, map[entities.OrderID]Order) error { return nil }
+func (noopOrdersSink) VerifyBucket(time.Time, int) error                       { return nil }
+func (noopOrdersSink) Close() error                                            { return nil }
+
+// syntheticFetchOut builds a fetchBuckets-shaped channel-of-channels from
+// already-resolved results, so processBuckets can be driven without a
+// database.
+func syntheticFetchOut(fetches []bucketFetch) <-chan (<-chan bucketFetch) {
+	out := make(chan (<-chan bucketFetch), len(fetches))
+	for _, f := range fetches {
+		ch := make(chan bucketFetch, 1)
+		ch <- f
+		close(ch)
+		out <- ch
+	}
+	close(out)
+	return out
+}
+
+// TestProcessBucketsCheckpointsInBucketOrder pins down the guarantee that
+// motivated splitting checkpointing out of the writer pool: even when a
+// later bucket's sink write finishes before an earlier one's (writers run
+// concurrently and out of order), the on-disk checkpoint must never jump
+// ahead to a bucket whose own write hasn't been confirmed yet.
+func TestProcessBucketsCheckpointsInBucketOrder(t *testing.T) {
+	dir := t.TempDir()
+	log := logging.NewLoggerFromConfig(logging.NewDefaultConfig()).Named("test")
+
+	end1 := time.Date(2024, 1, 1, 0, 5, 0, 0, time.UTC)
+	end2 := time.Date(2024, 1, 1, 0, 10, 0, 0, time.UTC)
+	end3 := time.Date(2024, 1, 1, 0, 15, 0, 0, time.UTC)
+
+	block2 := make(chan struct{})
+	depthSink := &blockingDepthSink{
+		block: map[time.Time]chan struct{}{end2: block2},
+		wrote: make(chan time.Time, 3),
+	}
+
+	fetchOut := syntheticFetchOut([]bucketFetch{
+		{bucketRange: bucketRange{start: end1.Add(-5 * time.Minute), end: end1}},
+		{bucketRange: bucketRange{start: end2.Add(-5 * time.Minute), end: end2}},
+		{bucketRange: bucketRange{start: end3.Add(-5 * time.Minute), end: end3}},
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- processBuckets(context.Background(), log, fetchOut,
+			map[entities.OrderID]Order{}, map[entities.MarketID]map[Level]int64{},
+			depthSink, noopOrdersSink{}, dir, 2)
+	}()
+
+	// With 2 writers, bucket 1 and bucket 3 can both be written while
+	// bucket 2 sits blocked: wait for both to actually finish writing.
+	seen := map[time.Time]bool{}
+	for len(seen) < 2 {
+		select {
+		case e := <-depthSink.wrote:
+			seen[e] = true
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for buckets 1 and 3 to finish writing, saw %v", seen)
+		}
+	}
+	if !seen[end1] || !seen[end3] {
+		t.Fatalf("expected buckets 1 and 3 to finish writing first, saw %v", seen)
+	}
+
+	// Bucket 1's checkpoint should (eventually) land, since its write is
+	// confirmed and nothing ahead of it is still pending.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(snapshotPath(dir, end1)); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for bucket 1's checkpoint to be saved")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Bucket 3's write already completed, but bucket 2's hasn't: its
+	// checkpoint (and bucket 2's) must not exist yet.
+	if _, err := os.Stat(snapshotPath(dir, end2)); !os.IsNotExist(err) {
+		t.Fatalf("checkpoint for bucket 2 should not exist yet (its write is still blocked): stat err = %v", err)
+	}
+	if _, err := os.Stat(snapshotPath(dir, end3)); !os.IsNotExist(err) {
+		t.Fatalf("checkpoint for bucket 3 must not be saved before bucket 2's, even though bucket 3's write finished first: stat err = %v", err)
+	}
+
+	close(block2)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("processBuckets: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for processBuckets to finish after unblocking bucket 2")
+	}
+
+	for _, end := range []time.Time{end1, end2, end3} {
+		if _, err := os.Stat(snapshotPath(dir, end)); err != nil {
+			t.Fatalf("expected checkpoint for %s to exist once processing finished: %v", end, err)
+		}
+	}
+}
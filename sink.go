@@ -0,0 +1,35 @@
+package main
+
+import (
+	"time"
+
+	"code.vegaprotocol.io/vega/datanode/entities"
+)
+
+// DepthSink receives one complete depth snapshot per bucket.
+// Implementations must be safe to call WriteBucket on repeatedly, once per
+// bucket, followed by exactly one call to Close once the exporter is done.
+type DepthSink interface {
+	WriteBucket(end time.Time, depth map[entities.MarketID]map[Level]int64) error
+
+	// VerifyBucket confirms that the bucket ending at end was durably
+	// written by a previous run, for --resume to check before trusting a
+	// checkpoint. Sinks with nothing on disk to check (e.g. the ephemeral
+	// gRPC stream) should return nil.
+	VerifyBucket(end time.Time) error
+
+	Close() error
+}
+
+// OrdersSink receives the full live-order book as of the end of each
+// bucket.
+type OrdersSink interface {
+	WriteBucket(end time.Time, liveOrders map[entities.OrderID]Order) error
+
+	// VerifyBucket confirms that the live-order book written for the bucket
+	// ending at end matches liveOrderCount, for --resume to check before
+	// trusting a checkpoint.
+	VerifyBucket(end time.Time, liveOrderCount int) error
+
+	Close() error
+}